@@ -0,0 +1,116 @@
+/*
+Copyright 2015 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// genconversion regenerates the conversion functions for a single
+// versioned API package and writes them, gofmt'd and with imports fixed
+// up, to -output-file. It exists so that regeneration can be driven from
+// a Makefile target or a `go generate` directive instead of only from
+// go test, as pkg/runtime/conversion_generation_test.go does.
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+
+	"github.com/GoogleCloudPlatform/kubernetes/pkg/api"
+	"github.com/GoogleCloudPlatform/kubernetes/pkg/runtime"
+
+	"github.com/golang/glog"
+	"golang.org/x/tools/imports"
+)
+
+var (
+	functionDest = flag.String("output-file", "-", "Output file; defaults to stdout")
+	version      = flag.String("version", "", "Version for conversion.")
+	inputPackage = flag.String("input-package", "github.com/GoogleCloudPlatform/kubernetes/pkg/api", "Import path of the internal API package the generated conversions target; written into the output under the \"newer\" alias.")
+	boilerplate  = flag.String("boilerplate", "boilerplate.go.txt", "Header file for generated files")
+)
+
+func main() {
+	flag.Parse()
+
+	var funcOut io.Writer
+	if *functionDest == "-" {
+		funcOut = os.Stdout
+	} else {
+		file, err := os.Create(*functionDest)
+		if err != nil {
+			glog.Fatalf("couldn't open %v: %v", *functionDest, err)
+		}
+		defer file.Close()
+		funcOut = file
+	}
+
+	g := runtime.NewGenerator(api.Scheme.Raw())
+	// *version's own types render unqualified, and api's render under the
+	// newer alias the generated file imports - Generator.goType derives a type's
+	// prefix from its own package, not from whichever side of the
+	// conversion is being generated, so these two registrations are all
+	// that's needed for both directions to come out right.
+	g.OverwritePackage(*version, "")
+	g.OverwritePackage("api", "newer")
+	for _, knownType := range api.Scheme.KnownTypes(*version) {
+		if err := g.GenerateConversionsForType(*version, knownType); err != nil {
+			glog.Errorf("error while generating conversion functions for %v: %v", knownType, err)
+		}
+	}
+
+	var functionBuf bytes.Buffer
+	if _, err := functionBuf.WriteString("// AUTO-GENERATED FUNCTIONS START HERE\n"); err != nil {
+		glog.Fatalf("error while writing generated code: %v", err)
+	}
+	if err := g.WriteConversionFunctions(&functionBuf); err != nil {
+		glog.Fatalf("error while writing conversion functions: %v", err)
+	}
+	if _, err := functionBuf.WriteString("// AUTO-GENERATED FUNCTIONS END HERE\n\n"); err != nil {
+		glog.Fatalf("error while writing generated code: %v", err)
+	}
+	if _, err := functionBuf.WriteString("func init() {\n"); err != nil {
+		glog.Fatalf("error while writing generated code: %v", err)
+	}
+	if _, err := functionBuf.WriteString("\terr := newer.Scheme.AddGeneratedConversionFuncs(\n"); err != nil {
+		glog.Fatalf("error while writing generated code: %v", err)
+	}
+	if err := g.WriteConversionFunctionNames(&functionBuf); err != nil {
+		glog.Fatalf("error while writing conversion function names: %v", err)
+	}
+	if _, err := functionBuf.WriteString("\t)\n\tif err != nil {\n\t\t// If one of the conversion functions is malformed, detect it\n\t\t// immediately.\n\t\tpanic(err)\n\t}\n}\n"); err != nil {
+		glog.Fatalf("error while writing generated code: %v", err)
+	}
+
+	boilerplateBytes, err := ioutil.ReadFile(*boilerplate)
+	if err != nil {
+		glog.Fatalf("couldn't read boilerplate file %v: %v", *boilerplate, err)
+	}
+
+	var source bytes.Buffer
+	source.Write(boilerplateBytes)
+	fmt.Fprintf(&source, "\npackage %s\n\nimport (\n\tnewer %q\n)\n\n", *version, *inputPackage)
+	source.Write(functionBuf.Bytes())
+
+	formatted, err := imports.Process(*functionDest, source.Bytes(), nil)
+	if err != nil {
+		glog.Fatalf("couldn't gofmt/fix imports for %v: %v\n%s", *inputPackage, err, source.String())
+	}
+
+	if _, err := funcOut.Write(formatted); err != nil {
+		glog.Fatalf("couldn't write to %v: %v", *functionDest, err)
+	}
+}
@@ -22,17 +22,70 @@ import (
 	"fmt"
 	"io"
 	"io/ioutil"
+	"math/rand"
 	"os"
+	"reflect"
 	"testing"
 
 	"github.com/GoogleCloudPlatform/kubernetes/pkg/api"
+	"github.com/GoogleCloudPlatform/kubernetes/pkg/api/resource"
+	"github.com/GoogleCloudPlatform/kubernetes/pkg/api/unversioned"
 	_ "github.com/GoogleCloudPlatform/kubernetes/pkg/api/v1"
 	_ "github.com/GoogleCloudPlatform/kubernetes/pkg/api/v1beta3"
 	"github.com/GoogleCloudPlatform/kubernetes/pkg/runtime"
+	"github.com/GoogleCloudPlatform/kubernetes/pkg/util"
 
 	"github.com/golang/glog"
+	"github.com/google/gofuzz"
 )
 
+// roundTripSkip lists, per version, the KnownTypes whose conversion is
+// intentionally lossy (e.g. fields that only exist on one side) and that
+// TestRoundTripTypes therefore skips instead of requiring deep equality.
+var roundTripSkip = map[string]map[string]bool{
+	"v1beta3": {},
+	"v1":      {},
+}
+
+// roundTripFuzzFuncs teaches the fuzzer how to produce values of the
+// handful of well-known wrapper types that a naive field-by-field fuzz
+// can't round-trip: resource.Quantity and util.IntOrString both have an
+// internal string/int duality that a random byte-level fuzz almost always
+// breaks, unversioned.Time truncates to one-second resolution on the wire,
+// and runtime.RawExtension is only meaningful once its Object has already
+// been serialized, which plain fuzzing can't produce. Without these,
+// TestRoundTripTypes fails on essentially every type that embeds
+// ObjectMeta, not on the semantic regressions it's meant to catch.
+func roundTripFuzzFuncs() []interface{} {
+	return []interface{}{
+		func(q *resource.Quantity, c fuzz.Continue) {
+			*q = *resource.NewQuantity(c.Int63(), resource.DecimalExponent)
+		},
+		func(t *unversioned.Time, c fuzz.Continue) {
+			// sub-second resolution isn't preserved across the wire
+			// format, so don't fuzz it in.
+			*t = unversioned.Unix(c.Int63n(1000000), 0)
+		},
+		func(s *util.IntOrString, c fuzz.Continue) {
+			if c.RandBool() {
+				s.Kind = util.IntstrInt
+				s.IntVal = int32(c.Intn(1000))
+				s.StrVal = ""
+			} else {
+				s.Kind = util.IntstrString
+				s.IntVal = 0
+				s.StrVal = c.RandString()
+			}
+		},
+		func(re *runtime.RawExtension, c fuzz.Continue) {
+			// RawExtension only round-trips once something has already
+			// serialized an Object into it; leave it empty rather than
+			// fuzzing bytes no decoder can make sense of.
+			*re = runtime.RawExtension{}
+		},
+	}
+}
+
 func generateConversions(t *testing.T, version string) (bytes.Buffer, bytes.Buffer) {
 	g := runtime.NewGenerator(api.Scheme.Raw())
 	g.OverwritePackage(version, "")
@@ -64,6 +117,37 @@ func generateConversions(t *testing.T, version string) (bytes.Buffer, bytes.Buff
 	return functions, names
 }
 
+func generateDeepCopies(t *testing.T, version string) (bytes.Buffer, bytes.Buffer) {
+	g := runtime.NewGenerator(api.Scheme.Raw())
+	g.OverwritePackage(version, "")
+	g.OverwritePackage("api", "newer")
+	for _, knownType := range api.Scheme.KnownTypes(version) {
+		if err := g.GenerateDeepCopiesForType(version, knownType); err != nil {
+			glog.Errorf("error while generating deep copy functions for %v: %v", knownType, err)
+		}
+	}
+
+	var functions bytes.Buffer
+	functionsWriter := bufio.NewWriter(&functions)
+	if err := g.WriteDeepCopyFunctions(functionsWriter); err != nil {
+		t.Fatalf("couldn't generate deep copy functions: %v", err)
+	}
+	if err := functionsWriter.Flush(); err != nil {
+		t.Fatalf("error while flushing writer")
+	}
+
+	var names bytes.Buffer
+	namesWriter := bufio.NewWriter(&names)
+	if err := g.WriteDeepCopyFunctionNames(namesWriter); err != nil {
+		t.Fatalf("couldn't generate deep copy function names: %v", err)
+	}
+	if err := namesWriter.Flush(); err != nil {
+		t.Fatalf("error while flushing writer")
+	}
+
+	return functions, names
+}
+
 func readLinesUntil(t *testing.T, reader *bufio.Reader, stop string, buffer *bytes.Buffer) error {
 	for {
 		line, err := reader.ReadString('\n')
@@ -117,6 +201,38 @@ func bufferExistingConversions(t *testing.T, fileName string) (bytes.Buffer, byt
 	return functions, names
 }
 
+func bufferExistingDeepCopies(t *testing.T, fileName string) (bytes.Buffer, bytes.Buffer) {
+	file, err := os.Open(fileName)
+	if err != nil {
+		t.Fatalf("couldn't open file %s", fileName)
+	}
+	defer file.Close()
+
+	reader := bufio.NewReader(file)
+
+	functionsPrefix := "// AUTO-GENERATED FUNCTIONS START HERE\n"
+	functionsSuffix := "// AUTO-GENERATED FUNCTIONS END HERE\n"
+	if err := readLinesUntil(t, reader, functionsPrefix, nil); err != nil {
+		t.Fatalf("error while parsing file: %v", err)
+	}
+	var functions bytes.Buffer
+	if err := readLinesUntil(t, reader, functionsSuffix, &functions); err != nil {
+		t.Fatalf("error while parsing file: %v", err)
+	}
+
+	functionNamesPrefix := "\terr := newer.Scheme.AddGeneratedDeepCopyFuncs(\n"
+	functionNamesSuffix := "\t)\n"
+	if err := readLinesUntil(t, reader, functionNamesPrefix, nil); err != nil {
+		t.Fatalf("error while parsing file: %v", err)
+	}
+	var names bytes.Buffer
+	if err := readLinesUntil(t, reader, functionNamesSuffix, &names); err != nil {
+		t.Fatalf("error while parsing file: %v", err)
+	}
+
+	return functions, names
+}
+
 func compareBuffers(t *testing.T, generatedFile string, existing, generated bytes.Buffer) bool {
 	ok := true
 	for {
@@ -167,3 +283,105 @@ func TestNoManualChangesToGenerateConversions(t *testing.T) {
 		}
 	}
 }
+
+// TestRoundTripTypes complements TestNoManualChangesToGenerateConversions:
+// that test only catches drift between the generator's output and the
+// checked-in source, but says nothing about whether the generated
+// functions are actually correct. Here we fuzz every KnownType in every
+// registered version and push it internal->versioned->internal (and the
+// reverse), requiring the object survive unchanged. This catches semantic
+// regressions in GenerateConversionsForType's output - a missed field or a
+// mishandled tag won't show up as a textual diff, but will show up here.
+func TestRoundTripTypes(t *testing.T) {
+	seed := int64(1234)
+	fuzzer := fuzz.New().NilChance(.5).NumElements(0, 1).RandSource(rand.NewSource(seed)).Funcs(roundTripFuzzFuncs()...)
+
+	versions := []string{"v1beta3", "v1"}
+
+	for _, version := range versions {
+		for _, knownType := range api.Scheme.KnownTypes(version) {
+			if roundTripSkip[version][knownType.Name()] {
+				continue
+			}
+			roundTrip(t, fuzzer, version, knownType)
+		}
+	}
+}
+
+// roundTrip fuzzes a new internal object of kind, converts it to version
+// and back, and fails the test if the result doesn't deep-equal the
+// original. It additionally exercises the versioned->internal->versioned
+// direction, since the two conversions are generated independently and can
+// drift from each other even when each individually round-trips.
+func roundTrip(t *testing.T, fuzzer *fuzz.Fuzzer, version string, kind reflect.Type) {
+	item, err := api.Scheme.New("", kind.Name())
+	if err != nil {
+		t.Errorf("Couldn't make a %v? %v", kind.Name(), err)
+		return
+	}
+	fuzzer.Fuzz(item)
+
+	versioned, err := api.Scheme.ConvertToVersion(item, version)
+	if err != nil {
+		t.Errorf("%v: %v (%#v)", kind.Name(), err, item)
+		return
+	}
+	internal, err := api.Scheme.ConvertToVersion(versioned, "")
+	if err != nil {
+		t.Errorf("%v: %v", kind.Name(), err)
+		return
+	}
+	if !reflect.DeepEqual(item, internal) {
+		t.Errorf("%s: diff: %v", kind.Name(), objDiff(item, internal))
+		return
+	}
+
+	fuzzedVersioned, err := api.Scheme.New(version, kind.Name())
+	if err != nil {
+		t.Errorf("Couldn't make a versioned %v? %v", kind.Name(), err)
+		return
+	}
+	fuzzer.Fuzz(fuzzedVersioned)
+
+	internalAgain, err := api.Scheme.ConvertToVersion(fuzzedVersioned, "")
+	if err != nil {
+		t.Errorf("%v: %v", kind.Name(), err)
+		return
+	}
+	versionedAgain, err := api.Scheme.ConvertToVersion(internalAgain, version)
+	if err != nil {
+		t.Errorf("%v: %v", kind.Name(), err)
+		return
+	}
+	if !reflect.DeepEqual(fuzzedVersioned, versionedAgain) {
+		t.Errorf("%s: diff: %v", kind.Name(), objDiff(fuzzedVersioned, versionedAgain))
+	}
+}
+
+func objDiff(a, b interface{}) string {
+	return fmt.Sprintf("a: %#v\nb: %#v", a, b)
+}
+
+func TestNoManualChangesToGenerateDeepCopies(t *testing.T) {
+	versions := []string{"v1beta3", "v1"}
+
+	for _, version := range versions {
+		fileName := fmt.Sprintf("../../pkg/api/%s/deep_copy_generated.go", version)
+
+		existingFunctions, existingNames := bufferExistingDeepCopies(t, fileName)
+		generatedFunctions, generatedNames := generateDeepCopies(t, version)
+
+		functionsTxt := fmt.Sprintf("%s.deep_copy.functions.txt", version)
+		ioutil.WriteFile(functionsTxt, generatedFunctions.Bytes(), os.FileMode(0644))
+
+		namesTxt := fmt.Sprintf("%s.deep_copy.names.txt", version)
+		ioutil.WriteFile(namesTxt, generatedNames.Bytes(), os.FileMode(0644))
+
+		if ok := compareBuffers(t, functionsTxt, existingFunctions, generatedFunctions); ok {
+			os.Remove(functionsTxt)
+		}
+		if ok := compareBuffers(t, namesTxt, existingNames, generatedNames); ok {
+			os.Remove(namesTxt)
+		}
+	}
+}
@@ -0,0 +1,512 @@
+/*
+Copyright 2015 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package runtime
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+)
+
+// Generator reflects over registered types and emits the conversion and
+// deep-copy functions that let the rest of the codebase avoid writing them
+// by hand. It is driven from a testing.T in conversion_generation_test.go
+// and from the standalone cmd/genconversion binary.
+//
+// A Generator is not safe for concurrent use.
+type Generator struct {
+	scheme *Scheme
+
+	// pkgOverwrites lets a caller rename the package a version is
+	// imported under in the generated source, e.g. so the internal API
+	// package can be referred to as "newer" instead of its real name.
+	pkgOverwrites map[string]string
+
+	// convertibles holds, in call order, every (in, out) type pair we've
+	// generated a conversion function for, so that the function bodies
+	// and the registration block stay in sync.
+	convertibles []typePair
+
+	// deepCopies holds, in call order, every named type we've generated
+	// a DeepCopy_<pkg>_<Type> function for. Generation is idempotent per
+	// type so the same type is never emitted twice, which is what breaks
+	// cycles in the reflected type graph.
+	deepCopies []reflect.Type
+	copied     map[reflect.Type]bool
+
+	conversionFuncs map[typePair]string
+	conversionNames map[typePair]string
+	deepCopyFuncs   map[reflect.Type]string
+
+	// backends receive a VisitType call for every type the generator's
+	// conversion and deep-copy passes walk over, so that output formats
+	// other than Go source (protobuf, OpenAPI) can be driven off the same
+	// single reflection pass instead of re-walking the type graph.
+	backends []OutputBackend
+}
+
+// OutputBackend is a codegen target that rides along on the reflection
+// pass GenerateConversionsForType and GenerateDeepCopiesForType already
+// perform. WriteConversionFunctions and WriteDeepCopyFunctions are the
+// Go-source backends built into Generator itself; RegisterBackend adds
+// others (see NewProtoBackend, NewOpenAPIBackend) without requiring a
+// second walk of the registered types.
+type OutputBackend interface {
+	// Name identifies the backend in error messages.
+	Name() string
+	// VisitType is called for every named struct type the generator
+	// encounters, in the version it was registered under. The same type
+	// may be visited more than once (once per conversion direction, and
+	// again by the deep-copy pass).
+	VisitType(version string, t reflect.Type) error
+	// Write emits the backend's accumulated output to w.
+	Write(w io.Writer) error
+}
+
+// RegisterBackend adds b to the set of backends notified by subsequent
+// GenerateConversionsForType and GenerateDeepCopiesForType calls. Backends
+// registered after types have already been generated only see types from
+// then on - register before generating if you want full coverage.
+func (g *Generator) RegisterBackend(b OutputBackend) {
+	g.backends = append(g.backends, b)
+}
+
+func (g *Generator) visitBackends(version string, t reflect.Type) error {
+	for _, b := range g.backends {
+		if err := b.VisitType(version, t); err != nil {
+			return fmt.Errorf("backend %s: %v", b.Name(), err)
+		}
+	}
+	return nil
+}
+
+// visitPair notifies backends about both sides of a conversion pair, under
+// whichever of fromVersion/toVersion is an actual registered version (the
+// internal hub is represented by "" and isn't itself a backend target).
+// generateConversionsBetween calls this for every pair it walks - including
+// ones discovered recursively from struct fields - so backends see nested
+// types (e.g. ObjectMeta, PodSpec) and not just the top-level KnownType
+// GenerateConversionsForType was called with.
+func (g *Generator) visitPair(fromVersion, toVersion string, in, out reflect.Type) error {
+	if fromVersion != "" {
+		if err := g.visitBackends(fromVersion, in); err != nil {
+			return err
+		}
+	}
+	if toVersion != "" {
+		if err := g.visitBackends(toVersion, out); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type typePair struct {
+	in, out reflect.Type
+}
+
+// NewGenerator returns a Generator that reflects over the types registered
+// with scheme.
+func NewGenerator(scheme *Scheme) *Generator {
+	return &Generator{
+		scheme:          scheme,
+		pkgOverwrites:   map[string]string{},
+		copied:          map[reflect.Type]bool{},
+		conversionFuncs: map[typePair]string{},
+		conversionNames: map[typePair]string{},
+		deepCopyFuncs:   map[reflect.Type]string{},
+	}
+}
+
+// OverwritePackage changes the prefix used to refer to pkg's types in the
+// generated source. An empty overwrite means pkg's types are referred to
+// unqualified - this is what a package uses for its own types, since a
+// file in package v1 doesn't need (and can't use) a "v1." prefix on its
+// own v1.Pod.
+func (g *Generator) OverwritePackage(pkg, overwrite string) {
+	g.pkgOverwrites[pkg] = overwrite
+}
+
+// pkgName returns the prefix to use for a type from pkg: the overwrite
+// registered for it via OverwritePackage, if any (which may be "" for "no
+// prefix"), or pkg itself otherwise.
+func (g *Generator) pkgName(pkg string) string {
+	if overwrite, ok := g.pkgOverwrites[pkg]; ok {
+		return overwrite
+	}
+	return pkg
+}
+
+// goType renders t as it should appear in generated Go source, taking any
+// OverwritePackage renames into account. The prefix is always derived from
+// t's own package - not from whichever version the caller happens to be
+// generating for - so it renders correctly regardless of which side of a
+// conversion t is on.
+func (g *Generator) goType(t reflect.Type) string {
+	if t.PkgPath() == "" {
+		return t.String()
+	}
+	parts := strings.Split(t.PkgPath(), "/")
+	pkg := parts[len(parts)-1]
+	if prefix := g.pkgName(pkg); prefix != "" {
+		return fmt.Sprintf("%s.%s", prefix, t.Name())
+	}
+	return t.Name()
+}
+
+// goTypeExpr renders t as a full Go type expression, recursing through
+// pointers, slices, maps and arrays so that compound field types (e.g.
+// []Container, map[string]string) get the same per-element package
+// rewriting goType applies to named types.
+func (g *Generator) goTypeExpr(t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.Ptr:
+		return "*" + g.goTypeExpr(t.Elem())
+	case reflect.Slice:
+		return "[]" + g.goTypeExpr(t.Elem())
+	case reflect.Array:
+		return fmt.Sprintf("[%d]%s", t.Len(), g.goTypeExpr(t.Elem()))
+	case reflect.Map:
+		return fmt.Sprintf("map[%s]%s", g.goTypeExpr(t.Key()), g.goTypeExpr(t.Elem()))
+	default:
+		return g.goType(t)
+	}
+}
+
+// GenerateConversionsForType reflects over t (a type registered under
+// version) and generates autoConvert_<version>_<Type>_To_api_<Type> and its
+// inverse, recursively generating conversions for the type of every
+// struct-valued field it discovers along the way. Element types of pointer,
+// slice and map fields are not walked; those fields are converted through
+// s.Convert at run time.
+func (g *Generator) GenerateConversionsForType(version string, t reflect.Type) error {
+	internal, err := g.scheme.internalType(t)
+	if err != nil {
+		return err
+	}
+	if err := g.generateConversionsBetween(version, "", t, internal); err != nil {
+		return err
+	}
+	if err := g.generateConversionsBetween("", version, internal, t); err != nil {
+		return err
+	}
+	return nil
+}
+
+// GenerateConversionsBetween reflects over t (a type registered under
+// fromVersion) and generates a direct fromVersion<->toVersion conversion
+// pair, without requiring either version to be the internal hub. When the
+// two types are not field-compatible (see fieldsCompatible) it generates
+// nothing and returns nil. This is
+// what lets ConvertVia take a hub-routed path and, when the generator
+// finds the two versions are already field-compatible, skip straight to
+// the cheaper direct conversion instead.
+//
+// Unlike GenerateConversionsForType, which always anchors one side to the
+// internal API, both fromVersion and toVersion here may be arbitrary
+// registered versions.
+func (g *Generator) GenerateConversionsBetween(fromVersion, toVersion string, t reflect.Type) error {
+	out, err := g.scheme.versionedType(toVersion, t)
+	if err != nil {
+		return err
+	}
+	if !fieldsCompatible(t, out) {
+		// Not structurally compatible enough for a direct conversion to
+		// be safe - simply don't generate one. ConvertVia's
+		// convertDirect will find no registered func for this pair and
+		// fall back to hub-routing on its own, which is the intended
+		// behavior here, not an error.
+		return nil
+	}
+	if err := g.generateConversionsBetween(fromVersion, toVersion, t, out); err != nil {
+		return err
+	}
+	return g.generateConversionsBetween(toVersion, fromVersion, out, t)
+}
+
+// fieldsCompatible reports whether in and out are structurally compatible
+// enough to be worth a direct conversion: every exported field of one has
+// a same-named, structurally compatible field in the other. Field types
+// are compared structurally rather than with ==, since the whole point of
+// a cross-version direct conversion is that the two sides are always
+// distinct named types (v1.PodSpec vs api.PodSpec) even when every field
+// in them matches up - requiring identical reflect.Types would reject
+// essentially every real type. Only struct, pointer, slice and map types
+// are compared structurally; any other field type (strings, numbers and
+// named types built on them) must be the identical reflect.Type on both
+// sides.
+func fieldsCompatible(in, out reflect.Type) bool {
+	return structurallyCompatible(in, out, map[typePair]bool{})
+}
+
+func structurallyCompatible(in, out reflect.Type, seen map[typePair]bool) bool {
+	if in.Kind() != out.Kind() {
+		return false
+	}
+	switch in.Kind() {
+	case reflect.Struct:
+		pair := typePair{in, out}
+		if seen[pair] {
+			// already checking this pair further up the call stack;
+			// treat it as compatible so a self-referential type (e.g.
+			// a field of its own pointer type) doesn't recurse forever.
+			return true
+		}
+		seen[pair] = true
+
+		if in.NumField() != out.NumField() {
+			return false
+		}
+		for i := 0; i < in.NumField(); i++ {
+			field := in.Field(i)
+			if field.PkgPath != "" {
+				continue
+			}
+			outField, ok := out.FieldByName(field.Name)
+			if !ok {
+				return false
+			}
+			if !structurallyCompatible(field.Type, outField.Type, seen) {
+				return false
+			}
+		}
+		return true
+	case reflect.Ptr, reflect.Slice:
+		return structurallyCompatible(in.Elem(), out.Elem(), seen)
+	case reflect.Map:
+		return structurallyCompatible(in.Key(), out.Key(), seen) && structurallyCompatible(in.Elem(), out.Elem(), seen)
+	default:
+		return in == out
+	}
+}
+
+func (g *Generator) generateConversionsBetween(fromVersion, toVersion string, in, out reflect.Type) error {
+	pair := typePair{in, out}
+	if _, ok := g.conversionFuncs[pair]; ok {
+		return nil
+	}
+	// reserve the slot before recursing so a type that refers to itself
+	// (directly or through a field) doesn't recurse forever.
+	g.conversionFuncs[pair] = ""
+	g.convertibles = append(g.convertibles, pair)
+
+	if in.Kind() != reflect.Struct || out.Kind() != reflect.Struct {
+		return fmt.Errorf("can only generate conversions between structs, got %v and %v", in, out)
+	}
+
+	if err := g.visitPair(fromVersion, toVersion, in, out); err != nil {
+		return err
+	}
+
+	name := conversionFuncName(fromVersion, toVersion, in, out)
+	g.conversionNames[pair] = name
+
+	var body bytes.Buffer
+	fmt.Fprintf(&body, "func auto%s(in *%s, out *%s, s conversion.Scope) error {\n",
+		name, g.goType(in), g.goType(out))
+	for i := 0; i < in.NumField(); i++ {
+		field := in.Field(i)
+		if field.PkgPath != "" {
+			// unexported field; conversions never touch these.
+			continue
+		}
+		outField, ok := out.FieldByName(field.Name)
+		if !ok {
+			return fmt.Errorf("%v has no field %s matching %v", out, field.Name, in)
+		}
+		if err := g.generateFieldConversion(fromVersion, toVersion, field, outField, &body); err != nil {
+			return err
+		}
+	}
+	fmt.Fprintf(&body, "\treturn nil\n}\n\n")
+
+	g.conversionFuncs[pair] = body.String()
+	return nil
+}
+
+func (g *Generator) generateFieldConversion(fromVersion, toVersion string, in, out reflect.StructField, w io.Writer) error {
+	switch in.Type.Kind() {
+	case reflect.Struct, reflect.Ptr, reflect.Slice, reflect.Map:
+		if in.Type.Kind() == reflect.Struct {
+			if err := g.generateConversionsBetween(fromVersion, toVersion, in.Type, out.Type); err != nil {
+				return err
+			}
+		}
+		fmt.Fprintf(w, "\tif err := s.Convert(&in.%s, &out.%s, 0); err != nil {\n\t\treturn err\n\t}\n", in.Name, out.Name)
+	default:
+		fmt.Fprintf(w, "\tout.%s = in.%s\n", out.Name, in.Name)
+	}
+	return nil
+}
+
+func conversionFuncName(fromVersion, toVersion string, in, out reflect.Type) string {
+	fromPkg := fromVersion
+	if fromPkg == "" {
+		fromPkg = "api"
+	}
+	toPkg := toVersion
+	if toPkg == "" {
+		toPkg = "api"
+	}
+	return fmt.Sprintf("Convert_%s_%s_To_%s_%s", fromPkg, in.Name(), toPkg, out.Name())
+}
+
+// WriteConversionFunctions writes every conversion function body generated
+// so far, in call order, to w.
+func (g *Generator) WriteConversionFunctions(w io.Writer) error {
+	for _, pair := range g.convertibles {
+		if _, err := io.WriteString(w, g.conversionFuncs[pair]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WriteConversionFunctionNames writes the newer.Scheme.AddGeneratedConversionFuncs
+// argument list - one function reference per line - for every conversion
+// generated so far, in call order.
+func (g *Generator) WriteConversionFunctionNames(w io.Writer) error {
+	for _, pair := range g.convertibles {
+		if _, err := fmt.Fprintf(w, "\t\tauto%s,\n", g.conversionNames[pair]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// deepCopyFuncName is exported-by-convention only through generated code;
+// it is the name every emitted deep-copy function for t uses.
+func deepCopyFuncName(t reflect.Type) string {
+	parts := strings.Split(t.PkgPath(), "/")
+	pkg := parts[len(parts)-1]
+	return fmt.Sprintf("DeepCopy_%s_%s", pkg, t.Name())
+}
+
+// GenerateDeepCopiesForType reflects over t (a type registered under
+// version) and generates a DeepCopy_<pkg>_<Type> function, recursively
+// generating deep-copy functions for every named struct type reachable
+// from its fields. Pointers, slices and maps are copied inline through the
+// Cloner rather than through their own function, as the conversion
+// generator converts them inline through s.Convert. The well-known struct
+// types isSpecialDeepCopyType lists (unversioned.Time, RawExtension) are
+// copied by plain assignment; the conversion generator has no such case
+// and walks them like any other struct.
+func (g *Generator) GenerateDeepCopiesForType(version string, t reflect.Type) error {
+	return g.generateDeepCopyFor(version, t)
+}
+
+func (g *Generator) generateDeepCopyFor(version string, t reflect.Type) error {
+	if t.Kind() != reflect.Struct {
+		return fmt.Errorf("can only generate deep copies for structs, got %v", t)
+	}
+	if g.copied[t] {
+		return nil
+	}
+	// reserve before recursing to break cycles (e.g. a type with a field
+	// of its own pointer type).
+	g.copied[t] = true
+	g.deepCopies = append(g.deepCopies, t)
+
+	if err := g.visitBackends(version, t); err != nil {
+		return err
+	}
+
+	var body bytes.Buffer
+	name := deepCopyFuncName(t)
+	fmt.Fprintf(&body, "func %s(in %s, out *%s, c *conversion.Cloner) error {\n", name, g.goType(t), g.goType(t))
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.PkgPath != "" {
+			continue
+		}
+		if err := g.generateFieldDeepCopy(version, field, &body); err != nil {
+			return err
+		}
+	}
+	fmt.Fprintf(&body, "\treturn nil\n}\n\n")
+
+	g.deepCopyFuncs[t] = body.String()
+	return nil
+}
+
+func (g *Generator) generateFieldDeepCopy(version string, field reflect.StructField, w io.Writer) error {
+	switch isSpecialDeepCopyType(field.Type) {
+	case true:
+		fmt.Fprintf(w, "\tout.%s = in.%s\n", field.Name, field.Name)
+		return nil
+	}
+
+	switch field.Type.Kind() {
+	case reflect.Struct:
+		if err := g.generateDeepCopyFor(version, field.Type); err != nil {
+			return err
+		}
+		fmt.Fprintf(w, "\tif err := %s(in.%s, &out.%s, c); err != nil {\n\t\treturn err\n\t}\n",
+			deepCopyFuncName(field.Type), field.Name, field.Name)
+	case reflect.Ptr, reflect.Slice, reflect.Map:
+		// Pointers, slices and maps are copied through the generic
+		// cloner rather than a dedicated function: their element type
+		// may vary per-instantiation (e.g. map[string]string vs
+		// map[string][]string) in a way a single named function can't
+		// capture, and the cloner already knows how to recurse.
+		fmt.Fprintf(w, "\tif newVal, err := c.DeepCopy(in.%s); err != nil {\n\t\treturn err\n\t} else {\n\t\tout.%s = newVal.(%s)\n\t}\n",
+			field.Name, field.Name, g.goTypeExpr(field.Type))
+	default:
+		fmt.Fprintf(w, "\tout.%s = in.%s\n", field.Name, field.Name)
+	}
+	return nil
+}
+
+// isSpecialDeepCopyType reports whether t is one of the handful of types
+// the generator knows are safe to copy by value assignment even though
+// they aren't plain scalars - e.g. unversioned.Time wraps a time.Time and
+// has no pointer/slice/map fields of its own.
+func isSpecialDeepCopyType(t reflect.Type) bool {
+	switch t.String() {
+	case "unversioned.Time", "util.Time", "runtime.RawExtension":
+		return true
+	}
+	return false
+}
+
+// WriteDeepCopyFunctions writes every deep-copy function body generated so
+// far, in call order, to w.
+func (g *Generator) WriteDeepCopyFunctions(w io.Writer) error {
+	for _, t := range g.deepCopies {
+		if _, err := io.WriteString(w, g.deepCopyFuncs[t]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WriteDeepCopyFunctionNames writes the newer.Scheme.AddGeneratedDeepCopyFuncs
+// argument list - one function reference per line - for every deep-copy
+// function generated so far, in call order. This matches
+// WriteDeepCopyFunctions, which emits bodies in the same order, so the two
+// halves of deep_copy_generated.go stay in sync - the same convention the
+// conversion functions use.
+func (g *Generator) WriteDeepCopyFunctionNames(w io.Writer) error {
+	for _, t := range g.deepCopies {
+		if _, err := fmt.Fprintf(w, "\t\t%s,\n", deepCopyFuncName(t)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,132 @@
+/*
+Copyright 2015 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package runtime
+
+import (
+	"encoding/json"
+	"io"
+	"reflect"
+	"strings"
+)
+
+// openAPISchema is the subset of an OpenAPI v2 "Schema Object" OpenAPIBackend
+// fills in. Field order in the marshalled JSON doesn't matter to any OpenAPI
+// consumer, so this deliberately doesn't try to preserve struct field order.
+type openAPISchema struct {
+	Type                 string                    `json:"type,omitempty"`
+	Format               string                    `json:"format,omitempty"`
+	Ref                  string                    `json:"$ref,omitempty"`
+	Items                *openAPISchema            `json:"items,omitempty"`
+	Properties           map[string]*openAPISchema `json:"properties,omitempty"`
+	AdditionalProperties *openAPISchema            `json:"additionalProperties,omitempty"`
+}
+
+// OpenAPIBackend is an OutputBackend that emits an OpenAPI v2
+// "definitions" document for every struct type it visits, walking the same
+// reflected type graph as the Go conversion/deep-copy backends.
+type OpenAPIBackend struct {
+	definitions map[string]*openAPISchema
+}
+
+// NewOpenAPIBackend returns an empty OpenAPIBackend.
+func NewOpenAPIBackend() *OpenAPIBackend {
+	return &OpenAPIBackend{definitions: map[string]*openAPISchema{}}
+}
+
+func (b *OpenAPIBackend) Name() string { return "openapi" }
+
+func (b *OpenAPIBackend) VisitType(version string, t reflect.Type) error {
+	if t.Kind() != reflect.Struct {
+		return nil
+	}
+	if _, ok := b.definitions[t.Name()]; ok {
+		return nil
+	}
+	b.definitions[t.Name()] = nil // reserve; structSchema refers to nested structs by $ref and does not recurse
+	b.definitions[t.Name()] = structSchema(t)
+	return nil
+}
+
+func structSchema(t reflect.Type) *openAPISchema {
+	schema := &openAPISchema{Type: "object", Properties: map[string]*openAPISchema{}}
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.PkgPath != "" {
+			continue
+		}
+		name := jsonFieldName(field)
+		if name == "-" {
+			continue
+		}
+		schema.Properties[name] = fieldSchema(field.Type)
+	}
+	return schema
+}
+
+func fieldSchema(t reflect.Type) *openAPISchema {
+	switch t.Kind() {
+	case reflect.Ptr:
+		return fieldSchema(t.Elem())
+	case reflect.Slice:
+		return &openAPISchema{Type: "array", Items: fieldSchema(t.Elem())}
+	case reflect.Map:
+		return &openAPISchema{Type: "object", AdditionalProperties: fieldSchema(t.Elem())}
+	case reflect.Struct:
+		return &openAPISchema{Ref: "#/definitions/" + t.Name()}
+	case reflect.String:
+		return &openAPISchema{Type: "string"}
+	case reflect.Bool:
+		return &openAPISchema{Type: "boolean"}
+	case reflect.Int, reflect.Int32:
+		return &openAPISchema{Type: "integer", Format: "int32"}
+	case reflect.Int64:
+		return &openAPISchema{Type: "integer", Format: "int64"}
+	case reflect.Float32, reflect.Float64:
+		return &openAPISchema{Type: "number", Format: "double"}
+	default:
+		return &openAPISchema{Type: "string"}
+	}
+}
+
+// jsonFieldName returns the name field is published under: its
+// `json:"name"` tag if present, falling back to the Go field name. A field
+// tagged `json:"-"` is reported as "-" so callers can skip it, matching
+// encoding/json's own convention. Embedded structs are not special-cased:
+// one with no tag name (e.g. `json:",inline"`) is reported under its Go
+// field name, where encoding/json would inline its fields instead.
+func jsonFieldName(field reflect.StructField) string {
+	tag := field.Tag.Get("json")
+	if tag == "" {
+		return field.Name
+	}
+	name := strings.Split(tag, ",")[0]
+	if name == "" {
+		return field.Name
+	}
+	return name
+}
+
+// Write emits {"definitions": {...}} for every visited type. encoding/json
+// marshals map keys in sorted order, so output is stable across runs.
+func (b *OpenAPIBackend) Write(w io.Writer) error {
+	doc := struct {
+		Definitions map[string]*openAPISchema `json:"definitions"`
+	}{Definitions: b.definitions}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(doc)
+}
@@ -0,0 +1,83 @@
+/*
+Copyright 2015 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package runtime
+
+import (
+	"errors"
+	"reflect"
+)
+
+// errNoDirectConversion is returned by convertDirect when the Scheme has no
+// conversion func registered for obj's concrete type directly into
+// targetGV - as opposed to one that's registered but failed when run,
+// which is a real error ConvertVia must not swallow.
+var errNoDirectConversion = errors.New("runtime: no direct conversion registered for this type pair")
+
+// ConvertVia converts obj (an object in some registered version) to
+// targetGV. If the generator has produced a direct obj-version<->targetGV
+// conversion (see Generator.GenerateConversionsBetween) that pair is used
+// directly; otherwise obj is routed through hubGV - normally the internal
+// API - composing the two generated <->hub conversions that
+// GenerateConversionsForType always produces.
+//
+// This lets callers write e.g. v1beta3 objects directly into v1 structs
+// without hand-rolling the intermediate marshalling themselves, while
+// still falling back to something that's guaranteed to exist.
+func (s *Scheme) ConvertVia(obj interface{}, targetGV, hubGV string) (interface{}, error) {
+	direct, err := s.convertDirect(obj, targetGV)
+	switch err {
+	case nil:
+		return direct, nil
+	case errNoDirectConversion:
+		// no direct <->targetGV conversion was generated for this type;
+		// fall through to hub-routing below.
+	default:
+		return nil, err
+	}
+
+	hub, err := s.ConvertToVersion(obj, hubGV)
+	if err != nil {
+		return nil, err
+	}
+	return s.ConvertToVersion(hub, targetGV)
+}
+
+// convertDirect converts obj into a new object of its own kind registered
+// under targetGV, using a conversion func registered directly between the
+// two - without going through the internal hub. It returns
+// errNoDirectConversion (and no other error) when no such func is
+// registered, so ConvertVia can tell "nothing to do here" apart from "the
+// registered conversion failed".
+func (s *Scheme) convertDirect(obj interface{}, targetGV string) (interface{}, error) {
+	objType := reflect.TypeOf(obj)
+	for objType.Kind() == reflect.Ptr {
+		objType = objType.Elem()
+	}
+
+	out, err := s.New(targetGV, objType.Name())
+	if err != nil {
+		return nil, err
+	}
+
+	if !s.Converter().HasConversionFunc(reflect.TypeOf(obj), reflect.TypeOf(out)) {
+		return nil, errNoDirectConversion
+	}
+	if err := s.Convert(obj, out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}